@@ -0,0 +1,153 @@
+package main
+
+import (
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/gorilla/websocket"
+)
+
+// proxyEvent is emitted by the hub once per service every time /pac,
+// /global, or /off mutates it, so a connected client can show live
+// per-interface progress instead of waiting for the aggregated response.
+type proxyEvent struct {
+	Service   string    `json:"service"`
+	Action    string    `json:"action"`
+	OK        bool      `json:"ok"`
+	Error     string    `json:"error,omitempty"`
+	Timestamp time.Time `json:"timestamp"`
+}
+
+// eventHub fans proxyEvents out to every subscribed WebSocket client.
+type eventHub struct {
+	mu          sync.Mutex
+	subscribers map[chan proxyEvent]struct{}
+}
+
+func newEventHub() *eventHub {
+	return &eventHub{
+		subscribers: make(map[chan proxyEvent]struct{}),
+	}
+}
+
+func (h *eventHub) subscribe() chan proxyEvent {
+	ch := make(chan proxyEvent, 16)
+
+	h.mu.Lock()
+	h.subscribers[ch] = struct{}{}
+	h.mu.Unlock()
+
+	return ch
+}
+
+func (h *eventHub) unsubscribe(ch chan proxyEvent) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if _, ok := h.subscribers[ch]; ok {
+		delete(h.subscribers, ch)
+		close(ch)
+	}
+}
+
+// publish fans evt out to every current subscriber. A subscriber whose
+// buffer is full misses evt (it stays subscribed) rather than being allowed
+// to block the mutation loop.
+func (h *eventHub) publish(evt proxyEvent) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	for ch := range h.subscribers {
+		select {
+		case ch <- evt:
+		default:
+			// Subscriber isn't keeping up; skip it for this event.
+		}
+	}
+}
+
+// publishResult builds a proxyEvent from a per-service mutation result and
+// publishes it.
+func (h *eventHub) publishResult(service, action string, err error) {
+	evt := proxyEvent{
+		Service:   service,
+		Action:    action,
+		OK:        err == nil,
+		Timestamp: time.Now(),
+	}
+	if err != nil {
+		evt.Error = err.Error()
+	}
+
+	h.publish(evt)
+}
+
+// checkWebSocketOrigin decides whether to accept a /events upgrade. A unix
+// socket can't be dialed cross-origin by a browser's WebSocket API, so any
+// Origin is fine there; everything else (e.g. an activated loopback TCP
+// listener) only accepts same-origin requests or clients that don't send an
+// Origin header at all (non-browser tools like curl/websocat).
+func (s *Server) checkWebSocketOrigin(r *http.Request) bool {
+	if s.isUnixSocket() {
+		return true
+	}
+
+	origin := r.Header.Get("Origin")
+	if origin == "" {
+		return true
+	}
+
+	originURL, err := url.Parse(origin)
+	if err != nil {
+		return false
+	}
+
+	return originURL.Host == r.Host
+}
+
+// handleEvents upgrades the connection to a WebSocket and streams every
+// proxyEvent published on s.events until the client disconnects.
+func (s *Server) handleEvents(c *gin.Context) {
+	upgrader := websocket.Upgrader{CheckOrigin: s.checkWebSocketOrigin}
+
+	conn, err := upgrader.Upgrade(c.Writer, c.Request, nil)
+	if err != nil {
+		return
+	}
+	defer conn.Close()
+
+	ch := s.events.subscribe()
+	defer s.events.unsubscribe(ch)
+
+	// Detect client disconnects (e.g. browser tab closed) by reading in the
+	// background; we don't expect any incoming messages. done is closed as
+	// soon as that happens, so the write loop below notices immediately
+	// instead of waiting on the next unrelated broadcast.
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for {
+			if _, _, err := conn.NextReader(); err != nil {
+				conn.Close()
+				return
+			}
+		}
+	}()
+
+	for {
+		select {
+		case evt, ok := <-ch:
+			if !ok {
+				return
+			}
+			if err := conn.WriteJSON(evt); err != nil {
+				return
+			}
+		case <-done:
+			return
+		}
+	}
+}
@@ -0,0 +1,179 @@
+package main
+
+import (
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/gorilla/websocket"
+)
+
+func TestEventHubPublishSubscribe(t *testing.T) {
+	hub := newEventHub()
+	ch := hub.subscribe()
+
+	hub.publishResult("Wi-Fi", "pac", nil)
+
+	select {
+	case evt := <-ch:
+		if evt.Service != "Wi-Fi" || evt.Action != "pac" || !evt.OK || evt.Error != "" {
+			t.Fatalf("unexpected event: %+v", evt)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("timed out waiting for published event")
+	}
+}
+
+func TestEventHubPublishResultError(t *testing.T) {
+	hub := newEventHub()
+	ch := hub.subscribe()
+
+	wantErr := errors.New("boom")
+	hub.publishResult("Ethernet", "off", wantErr)
+
+	evt := <-ch
+	if evt.OK {
+		t.Fatal("expected OK=false for a non-nil error")
+	}
+	if evt.Error != wantErr.Error() {
+		t.Fatalf("expected error %q, got %q", wantErr.Error(), evt.Error)
+	}
+}
+
+func TestEventHubUnsubscribeClosesChannel(t *testing.T) {
+	hub := newEventHub()
+	ch := hub.subscribe()
+	hub.unsubscribe(ch)
+
+	if _, ok := <-ch; ok {
+		t.Fatal("expected channel to be closed after unsubscribe")
+	}
+}
+
+func TestEventHubPublishDoesNotBlockOnSlowSubscriber(t *testing.T) {
+	hub := newEventHub()
+	ch := hub.subscribe()
+
+	// The channel buffer is 16; publishing more than that must not block.
+	for i := 0; i < 32; i++ {
+		hub.publishResult("Wi-Fi", "pac", nil)
+	}
+
+	close(ch) // reaching here without a timeout means publish never blocked
+}
+
+func TestCheckWebSocketOriginUnixSocketAllowsAny(t *testing.T) {
+	dir := t.TempDir()
+	listener, err := net.Listen("unix", dir+"/test.sock")
+	if err != nil {
+		t.Fatalf("failed to create unix listener: %v", err)
+	}
+	defer listener.Close()
+
+	s := &Server{listener: listener}
+
+	req := httptest.NewRequest(http.MethodGet, "/events", nil)
+	req.Header.Set("Origin", "http://evil.example")
+
+	if !s.checkWebSocketOrigin(req) {
+		t.Fatal("expected unix-socket server to accept any Origin")
+	}
+}
+
+func TestCheckWebSocketOriginTCPRejectsCrossOrigin(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed to create loopback listener: %v", err)
+	}
+	defer listener.Close()
+
+	s := &Server{listener: listener}
+
+	req := httptest.NewRequest(http.MethodGet, "http://127.0.0.1:1087/events", nil)
+	req.Host = "127.0.0.1:1087"
+	req.Header.Set("Origin", "http://evil.example")
+
+	if s.checkWebSocketOrigin(req) {
+		t.Fatal("expected a cross-origin request to be rejected on a TCP listener")
+	}
+}
+
+func TestCheckWebSocketOriginTCPAllowsSameOrigin(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed to create loopback listener: %v", err)
+	}
+	defer listener.Close()
+
+	s := &Server{listener: listener}
+
+	req := httptest.NewRequest(http.MethodGet, "http://127.0.0.1:1087/events", nil)
+	req.Host = "127.0.0.1:1087"
+	req.Header.Set("Origin", "http://127.0.0.1:1087")
+
+	if !s.checkWebSocketOrigin(req) {
+		t.Fatal("expected a same-origin request to be accepted")
+	}
+}
+
+func TestHandleEventsUnsubscribesPromptlyOnDisconnect(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	hub := newEventHub()
+	s := &Server{engine: gin.New(), events: hub}
+	s.engine.GET("/events", s.handleEvents)
+
+	httpSrv := httptest.NewServer(s.engine)
+	defer httpSrv.Close()
+
+	wsURL := "ws" + strings.TrimPrefix(httpSrv.URL, "http") + "/events"
+	conn, _, err := websocket.DefaultDialer.Dial(wsURL, nil)
+	if err != nil {
+		t.Fatalf("failed to dial /events: %v", err)
+	}
+
+	if !waitForSubscriberCount(hub, 1, time.Second) {
+		t.Fatal("expected handleEvents to subscribe to the hub")
+	}
+
+	conn.Close()
+
+	if !waitForSubscriberCount(hub, 0, time.Second) {
+		t.Fatal("expected handleEvents to unsubscribe promptly after disconnect, without waiting for another broadcast")
+	}
+}
+
+func waitForSubscriberCount(hub *eventHub, want int, timeout time.Duration) bool {
+	deadline := time.Now().Add(timeout)
+	for time.Now().Before(deadline) {
+		hub.mu.Lock()
+		got := len(hub.subscribers)
+		hub.mu.Unlock()
+		if got == want {
+			return true
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	return false
+}
+
+func TestCheckWebSocketOriginTCPAllowsNoOriginHeader(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed to create loopback listener: %v", err)
+	}
+	defer listener.Close()
+
+	s := &Server{listener: listener}
+
+	req := httptest.NewRequest(http.MethodGet, "http://127.0.0.1:1087/events", nil)
+
+	if !s.checkWebSocketOrigin(req) {
+		t.Fatal("expected a request with no Origin header (e.g. a CLI client) to be accepted")
+	}
+}
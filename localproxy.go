@@ -0,0 +1,368 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+// proxyDecision is the outcome of routing a single request: either connect
+// DIRECT, or via an upstream PROXY/SOCKS address (dialed as plain TCP
+// either way).
+type proxyDecision struct {
+	direct bool
+	addr   string // host:port of the upstream, when !direct
+}
+
+// parsePACResult takes a PAC FindProxyForURL() return value such as
+// "PROXY 1.2.3.4:8080; DIRECT" and returns the first decision we
+// understand.
+func parsePACResult(result string) proxyDecision {
+	for _, part := range strings.Split(result, ";") {
+		fields := strings.Fields(strings.TrimSpace(part))
+		if len(fields) == 0 {
+			continue
+		}
+
+		switch strings.ToUpper(fields[0]) {
+		case "DIRECT":
+			return proxyDecision{direct: true}
+		case "PROXY", "SOCKS", "SOCKS5":
+			if len(fields) >= 2 {
+				return proxyDecision{addr: fields[1]}
+			}
+		}
+	}
+
+	return proxyDecision{direct: true}
+}
+
+// gfwList is an alternate, simpler rule source to PAC: a set of domains
+// refreshed periodically from sourceURL. When configured, only hosts that
+// match the list are routed through the PAC-resolved proxy; everything else
+// goes DIRECT without even consulting the PAC script.
+type gfwList struct {
+	mu      sync.RWMutex
+	domains map[string]struct{}
+
+	sourceURL string
+	client    *http.Client
+
+	stop chan struct{}
+}
+
+func newGFWList(sourceURL string, refreshInterval time.Duration) *gfwList {
+	g := &gfwList{
+		domains:   map[string]struct{}{},
+		sourceURL: sourceURL,
+		client:    &http.Client{Timeout: 10 * time.Second},
+		stop:      make(chan struct{}),
+	}
+
+	if refreshInterval <= 0 {
+		refreshInterval = time.Hour
+	}
+
+	go g.refreshLoop(refreshInterval)
+
+	return g
+}
+
+// Stop ends the refresh loop goroutine. Callers must stop a gfwList before
+// dropping the last reference to it, or the goroutine (and its http.Client)
+// leaks for the life of the process.
+func (g *gfwList) Stop() {
+	close(g.stop)
+}
+
+func (g *gfwList) refreshLoop(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		_ = g.refresh() // keep serving the last good list; retry next tick
+		select {
+		case <-ticker.C:
+		case <-g.stop:
+			return
+		}
+	}
+}
+
+func (g *gfwList) refresh() error {
+	resp, err := g.client.Get(g.sourceURL)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("failed to fetch GFW list: unexpected status %s", resp.Status)
+	}
+
+	domains := map[string]struct{}{}
+	scanner := bufio.NewScanner(resp.Body)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		domains[line] = struct{}{}
+	}
+	if err := scanner.Err(); err != nil {
+		return err
+	}
+
+	g.mu.Lock()
+	g.domains = domains
+	g.mu.Unlock()
+
+	return nil
+}
+
+// matches reports whether host or one of its parent domains is on the list.
+func (g *gfwList) matches(host string) bool {
+	g.mu.RLock()
+	defer g.mu.RUnlock()
+
+	for h := host; ; {
+		if _, ok := g.domains[h]; ok {
+			return true
+		}
+		dot := strings.Index(h, ".")
+		if dot < 0 {
+			return false
+		}
+		h = h[dot+1:]
+	}
+}
+
+// localProxy is an embedded HTTP CONNECT + forward proxy that decides each
+// request's route via a PAC script (and, optionally, a GFW-style domain
+// list), instead of relying on the OS's own PAC evaluator.
+type localProxy struct {
+	addr   string
+	engine *pacEngine
+	srv    *http.Server
+
+	gfwMu sync.RWMutex
+	gfw   *gfwList
+}
+
+func newLocalProxy(addr string, engine *pacEngine, gfw *gfwList) *localProxy {
+	p := &localProxy{addr: addr, engine: engine, gfw: gfw}
+	p.srv = &http.Server{Addr: addr, Handler: p}
+	return p
+}
+
+// currentGFW returns the GFW list currently consulted by decide, or nil if
+// none is configured.
+func (p *localProxy) currentGFW() *gfwList {
+	p.gfwMu.RLock()
+	defer p.gfwMu.RUnlock()
+	return p.gfw
+}
+
+// setGFW swaps the GFW list consulted by decide. Passing a nil gfw disables
+// GFW-based filtering (every host falls through to the PAC script).
+func (p *localProxy) setGFW(gfw *gfwList) {
+	p.gfwMu.Lock()
+	defer p.gfwMu.Unlock()
+	p.gfw = gfw
+}
+
+func (p *localProxy) Start() error {
+	listener, err := net.Listen("tcp", p.addr)
+	if err != nil {
+		return err
+	}
+
+	go func() {
+		if err := p.srv.Serve(listener); err != nil && err != http.ErrServerClosed {
+			// Server error, silently continue
+		}
+	}()
+
+	return nil
+}
+
+func (p *localProxy) decide(rawURL, host string) proxyDecision {
+	if gfw := p.currentGFW(); gfw != nil && !gfw.matches(host) {
+		return proxyDecision{direct: true}
+	}
+
+	if p.engine != nil {
+		if result, err := p.engine.FindProxyForURL(rawURL, host); err == nil {
+			return parsePACResult(result)
+		}
+	}
+
+	return proxyDecision{direct: true}
+}
+
+func (p *localProxy) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method == http.MethodConnect {
+		p.handleConnect(w, r)
+		return
+	}
+	p.handleForward(w, r)
+}
+
+func (p *localProxy) handleConnect(w http.ResponseWriter, r *http.Request) {
+	decision := p.decide("https://"+r.Host+"/", r.URL.Hostname())
+
+	dialAddr := r.Host
+	if !decision.direct {
+		dialAddr = decision.addr
+	}
+
+	upstream, err := net.DialTimeout("tcp", dialAddr, 10*time.Second)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadGateway)
+		return
+	}
+	defer upstream.Close()
+
+	if !decision.direct {
+		// Ask the upstream proxy to open the real tunnel on our behalf.
+		fmt.Fprintf(upstream, "CONNECT %s HTTP/1.1\r\nHost: %s\r\n\r\n", r.Host, r.Host)
+		resp, err := http.ReadResponse(bufio.NewReader(upstream), r)
+		if err != nil || resp.StatusCode != http.StatusOK {
+			http.Error(w, "upstream proxy CONNECT failed", http.StatusBadGateway)
+			return
+		}
+	}
+
+	hijacker, ok := w.(http.Hijacker)
+	if !ok {
+		http.Error(w, "connection does not support hijacking", http.StatusInternalServerError)
+		return
+	}
+
+	client, _, err := hijacker.Hijack()
+	if err != nil {
+		return
+	}
+	defer client.Close()
+
+	client.Write([]byte("HTTP/1.1 200 Connection Established\r\n\r\n"))
+
+	relay(client, upstream)
+}
+
+func (p *localProxy) handleForward(w http.ResponseWriter, r *http.Request) {
+	decision := p.decide(r.URL.String(), r.URL.Hostname())
+
+	transport := http.DefaultTransport
+	if !decision.direct {
+		upstreamURL := &url.URL{Scheme: "http", Host: decision.addr}
+		transport = &http.Transport{Proxy: http.ProxyURL(upstreamURL)}
+	}
+
+	r.RequestURI = ""
+	resp, err := transport.RoundTrip(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadGateway)
+		return
+	}
+	defer resp.Body.Close()
+
+	for key, values := range resp.Header {
+		for _, value := range values {
+			w.Header().Add(key, value)
+		}
+	}
+	w.WriteHeader(resp.StatusCode)
+	io.Copy(w, resp.Body)
+}
+
+// ensureNativeProxy starts the embedded native PAC proxy on first use. On
+// subsequent calls it reloads the PAC script and reconciles the GFW list
+// against whatever's currently running, but the listening port can't be
+// changed without restarting the proxy, so a port change is rejected with an
+// explicit error instead of being silently dropped.
+func (s *Server) ensureNativeProxy(pacURL string, port int, gfwURL string, gfwRefresh time.Duration) error {
+	s.nativeProxyMu.Lock()
+	defer s.nativeProxyMu.Unlock()
+
+	wantAddr := fmt.Sprintf("127.0.0.1:%d", port)
+
+	if s.nativeProxy != nil {
+		if s.nativeProxy.addr != wantAddr {
+			return fmt.Errorf("native PAC proxy is already running on %s; stop it before changing the port to %s", s.nativeProxy.addr, wantAddr)
+		}
+
+		if err := s.nativeProxy.engine.Reload(pacURL); err != nil {
+			return err
+		}
+
+		return reconcileGFW(s.nativeProxy, gfwURL, gfwRefresh)
+	}
+
+	engine, err := newPACEngine(pacURL)
+	if err != nil {
+		return err
+	}
+
+	var gfw *gfwList
+	if gfwURL != "" {
+		gfw = newGFWList(gfwURL, gfwRefresh)
+	}
+
+	proxy := newLocalProxy(wantAddr, engine, gfw)
+	if err := proxy.Start(); err != nil {
+		return err
+	}
+
+	s.nativeProxy = proxy
+	return nil
+}
+
+// reconcileGFW updates proxy's GFW list to match gfwURL if it has changed
+// since the proxy started: clearing it when gfwURL is now empty, replacing
+// it (and its refresh loop) when the source URL changed, and leaving it
+// alone otherwise.
+func reconcileGFW(proxy *localProxy, gfwURL string, gfwRefresh time.Duration) error {
+	current := proxy.currentGFW()
+
+	if gfwURL == "" {
+		if current != nil {
+			proxy.setGFW(nil)
+			current.Stop()
+		}
+		return nil
+	}
+
+	if current != nil && current.sourceURL == gfwURL {
+		return nil
+	}
+
+	proxy.setGFW(newGFWList(gfwURL, gfwRefresh))
+	if current != nil {
+		current.Stop()
+	}
+	return nil
+}
+
+// relay pipes bytes bidirectionally between two already-connected sockets
+// until either side closes.
+func relay(a, b io.ReadWriteCloser) {
+	done := make(chan struct{}, 2)
+
+	go func() {
+		io.Copy(a, b)
+		done <- struct{}{}
+	}()
+	go func() {
+		io.Copy(b, a)
+		done <- struct{}{}
+	}()
+
+	<-done
+}
@@ -0,0 +1,97 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestParsePACResult(t *testing.T) {
+	cases := []struct {
+		result string
+		want   proxyDecision
+	}{
+		{"DIRECT", proxyDecision{direct: true}},
+		{"PROXY 1.2.3.4:8080", proxyDecision{addr: "1.2.3.4:8080"}},
+		{"PROXY 1.2.3.4:8080; DIRECT", proxyDecision{addr: "1.2.3.4:8080"}},
+		{"SOCKS5 10.0.0.1:1080; PROXY 1.2.3.4:8080", proxyDecision{addr: "10.0.0.1:1080"}},
+		{"", proxyDecision{direct: true}},
+		{"  ", proxyDecision{direct: true}},
+	}
+
+	for _, tc := range cases {
+		if got := parsePACResult(tc.result); got != tc.want {
+			t.Errorf("parsePACResult(%q) = %+v, want %+v", tc.result, got, tc.want)
+		}
+	}
+}
+
+func TestGFWListMatches(t *testing.T) {
+	g := &gfwList{domains: map[string]struct{}{"example.com": {}}}
+
+	if !g.matches("example.com") {
+		t.Error("expected exact domain to match")
+	}
+	if !g.matches("www.example.com") {
+		t.Error("expected subdomain to match its parent domain")
+	}
+	if g.matches("example.org") {
+		t.Error("expected unrelated domain to not match")
+	}
+}
+
+func TestReconcileGFWAddsAndRemoves(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+		w.Write([]byte("allowed.example\n"))
+	}))
+	defer srv.Close()
+
+	proxy := newLocalProxy("127.0.0.1:0", nil, nil)
+
+	if err := reconcileGFW(proxy, srv.URL, time.Hour); err != nil {
+		t.Fatalf("unexpected error adding a GFW list: %v", err)
+	}
+	if proxy.currentGFW() == nil {
+		t.Fatal("expected a GFW list to be configured")
+	}
+
+	if err := reconcileGFW(proxy, srv.URL, time.Hour); err != nil {
+		t.Fatalf("unexpected error re-applying the same GFW url: %v", err)
+	}
+	same := proxy.currentGFW()
+	if same == nil {
+		t.Fatal("expected the GFW list to remain configured")
+	}
+
+	if err := reconcileGFW(proxy, "", time.Hour); err != nil {
+		t.Fatalf("unexpected error clearing the GFW list: %v", err)
+	}
+	if proxy.currentGFW() != nil {
+		t.Fatal("expected the GFW list to be cleared once gfwURL is empty")
+	}
+}
+
+func TestGFWListStopEndsRefreshLoop(t *testing.T) {
+	var requests int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&requests, 1)
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer srv.Close()
+
+	g := newGFWList(srv.URL, 10*time.Millisecond)
+
+	// Let a few refresh ticks land, then stop and record the count.
+	time.Sleep(50 * time.Millisecond)
+	g.Stop()
+	stoppedAt := atomic.LoadInt32(&requests)
+
+	// If the loop were still running, several more ticks would have fired.
+	time.Sleep(100 * time.Millisecond)
+	if got := atomic.LoadInt32(&requests); got != stoppedAt {
+		t.Fatalf("expected no further requests after Stop, got %d more", got-stoppedAt)
+	}
+}
@@ -8,16 +8,20 @@ import (
 	"net/http"
 	"net/url"
 	"os"
-	"os/exec"
 	"os/signal"
 	"strconv"
 	"strings"
+	"sync"
 	"syscall"
 	"time"
 
 	"github.com/gin-gonic/gin"
 )
 
+// defaultNativeProxyPort is used for native PAC evaluation mode when the
+// request doesn't specify one.
+const defaultNativeProxyPort = 1087
+
 const Version = "1.0.0"
 
 func init() {
@@ -27,20 +31,53 @@ func init() {
 }
 
 type Server struct {
-	engine   *gin.Engine
-	addr     string
-	srv      *http.Server
-	listener net.Listener
+	engine    *gin.Engine
+	addr      string
+	srv       *http.Server
+	listener  net.Listener
+	activated bool // true when the listener was inherited via socket activation
+	events    *eventHub
+
+	nativeProxyMu sync.Mutex
+	nativeProxy   *localProxy // non-nil once native PAC evaluation mode has been started
 }
 
 type Pac struct {
 	URL string `json:"url"`
+	// Services restricts the change to these network services. Empty means
+	// "all services", matching prior behavior.
+	Services []string `json:"services"`
+
+	// Native, when true, evaluates the PAC script in-process (see
+	// pacengine.go) and points services at an embedded local proxy instead
+	// of handing the PAC URL to networksetup.
+	Native bool `json:"native"`
+	// Port is the localhost port the embedded proxy listens on in native
+	// mode. Defaults to defaultNativeProxyPort.
+	Port int `json:"port"`
+	// GFWListURL, if set in native mode, restricts proxying to the domains
+	// on this list (refreshed periodically); everything else goes DIRECT
+	// without even consulting the PAC script.
+	GFWListURL string `json:"gfw_list_url"`
+	// GFWRefreshSeconds controls how often GFWListURL is re-fetched.
+	GFWRefreshSeconds int `json:"gfw_refresh_seconds"`
 }
 
 type Global struct {
 	HOST   string `json:"host"`
 	PORT   string `json:"port"`
 	BYPASS string `json:"bypass"`
+	// Services restricts the change to these network services. Empty means
+	// "all services", matching prior behavior.
+	Services []string `json:"services"`
+}
+
+// OffRequest is the optional JSON body accepted by GET /off.
+type OffRequest struct {
+	Restore bool `json:"restore"`
+	// Services restricts the change to these network services. Empty means
+	// "all services", matching prior behavior.
+	Services []string `json:"services"`
 }
 
 // Validate PAC URL
@@ -72,19 +109,7 @@ func validateGlobalProxy(host, port, bypass string) error {
 		return errors.New("port must be numeric")
 	}
 
-	// Comma-separated and space-separated bypass domain lists
-	var domains []string
-	if strings.Contains(bypass, ",") {
-		domains = strings.Split(bypass, ",")
-	} else {
-		domains = strings.Split(bypass, " ")
-	}
-
-	for _, domain := range domains {
-		domain = strings.TrimSpace(domain)
-		if domain == "" {
-			continue
-		}
+	for _, domain := range splitBypassDomains(bypass) {
 		// Allow <local> format, and wildcard *
 		if strings.ContainsAny(domain, "&|;`$(){}[]\\") {
 			// Whether special format like <local>
@@ -97,137 +122,66 @@ func validateGlobalProxy(host, port, bypass string) error {
 	return nil
 }
 
-// Get network service list
-func getNetworkServices() ([]string, error) {
-	cmd := exec.Command("networksetup", "-listnetworkserviceorder")
-	output, err := cmd.Output()
-	if err != nil {
-		return nil, err
+// splitBypassDomains parses a comma- or space-separated bypass domain list
+// into its individual, trimmed entries.
+func splitBypassDomains(bypass string) []string {
+	var raw []string
+	if strings.Contains(bypass, ",") {
+		raw = strings.Split(bypass, ",")
+	} else {
+		raw = strings.Split(bypass, " ")
 	}
 
-	lines := strings.Split(string(output), "\n")
-	var services []string
-
-	for _, line := range lines {
-
-		// Skip hardware port lines like "(Hardware Port: ..., Device: ...)"
-		if strings.HasPrefix(line, "(") && strings.Contains(line, ")") && !strings.Contains(line, "Hardware Port:") {
-
-			parts := strings.SplitN(line, ") ", 2)
-			if len(parts) == 2 {
-				service := strings.TrimSpace(parts[1])
-				if service != "" && service != "*" {
-
-					service = strings.TrimPrefix(service, "*")
-					service = strings.TrimSpace(service)
-					services = append(services, service)
-				}
-			}
+	var domains []string
+	for _, domain := range raw {
+		domain = strings.TrimSpace(domain)
+		if domain != "" {
+			domains = append(domains, domain)
 		}
 	}
 
-	if len(services) == 0 {
-		return nil, errors.New("no network services found")
-	}
+	return domains
+}
 
-	return services, nil
+// getNetworkServices returns the active network services, in priority
+// order. The actual lookup is backend-specific: see
+// getNetworkServicesBackend in proxybackend_default.go (networksetup, via
+// proxybackend_exec.go) or proxybackend_sc_darwin.go (cgo build against
+// SystemConfiguration.framework).
+func getNetworkServices() ([]string, error) {
+	return getNetworkServicesBackend()
 }
 
-// Turn off all proxies for a service
+// turnOffProxies disables every proxy type for service. It's the single
+// mutation choke point every other setter goes through, which is also why
+// it's where we snapshot the service's prior configuration.
 func turnOffProxies(service string) error {
-	commands := [][]string{
-		{"networksetup", "-setautoproxystate", service, "off"},
-		{"networksetup", "-setproxyautodiscovery", service, "off"},
-		{"networksetup", "-setwebproxystate", service, "off"},
-		{"networksetup", "-setsecurewebproxystate", service, "off"},
-		{"networksetup", "-setsocksfirewallproxystate", service, "off"},
-	}
-
-	for _, args := range commands {
-		cmd := exec.Command(args[0], args[1:]...)
-		if err := cmd.Run(); err != nil {
-			return err
-		}
+	// Capture the service's current configuration before the first mutation
+	// so it can be restored later via /restore, even if we crash mid-way.
+	if err := ensureSnapshot(service); err != nil {
+		return err
 	}
 
-	return nil
+	return turnOffProxiesBackend(service)
 }
 
-// Set PAC proxy for a service
+// setPACProxy points service at a PAC URL.
 func setPACProxy(service, pacURL string) error {
 	if err := turnOffProxies(service); err != nil {
 		return err
 	}
 
-	// Set PAC URL
-	cmd1 := exec.Command("networksetup", "-setautoproxyurl", service, pacURL)
-	if err := cmd1.Run(); err != nil {
-		return err
-	}
-
-	// Enable PAC
-	cmd2 := exec.Command("networksetup", "-setautoproxystate", service, "on")
-	if err := cmd2.Run(); err != nil {
-		return err
-	}
-
-	cmd3 := exec.Command("networksetup", "-setproxyautodiscovery", service, "on")
-	if err := cmd3.Run(); err != nil {
-		return err
-	}
-
-	return nil
+	return applyPACProxyBackend(service, pacURL)
 }
 
-// Set global proxy for a service
+// setGlobalProxy points service at a fixed host:port for web/secure-web/SOCKS
+// traffic, with an optional bypass list.
 func setGlobalProxy(service, host, port, bypass string) error {
 	if err := turnOffProxies(service); err != nil {
 		return err
 	}
 
-	commands := [][]string{
-		{"networksetup", "-setwebproxy", service, host, port},
-		{"networksetup", "-setsecurewebproxy", service, host, port},
-		{"networksetup", "-setsocksfirewallproxy", service, host, port},
-	}
-
-	for _, args := range commands {
-		cmd := exec.Command(args[0], args[1:]...)
-		if err := cmd.Run(); err != nil {
-			return err
-		}
-	}
-
-	// Set bypass domains if provided
-	if bypass != "" {
-		// Split bypass domains using the same logic as validation
-		var domains []string
-		if strings.Contains(bypass, ",") {
-			domains = strings.Split(bypass, ",")
-		} else {
-			domains = strings.Split(bypass, " ")
-		}
-
-		// prepare arguments
-		var cleanDomains []string
-		for _, domain := range domains {
-			domain = strings.TrimSpace(domain)
-			if domain != "" {
-				cleanDomains = append(cleanDomains, domain)
-			}
-		}
-
-		if len(cleanDomains) > 0 {
-			args := []string{"-setproxybypassdomains", service}
-			args = append(args, cleanDomains...)
-			cmd := exec.Command("networksetup", args...)
-			if err := cmd.Run(); err != nil {
-				return err
-			}
-		}
-	}
-
-	return nil
+	return applyGlobalProxyBackend(service, host, port, bypass)
 }
 
 func NewServer(addr string) *Server {
@@ -240,6 +194,7 @@ func NewServer(addr string) *Server {
 		engine: engine,
 		addr:   addr,
 		srv:    srv,
+		events: newEventHub(),
 	}
 }
 
@@ -264,7 +219,7 @@ func (s *Server) setupRoutes() {
 		}
 
 		// Get all network services
-		services, err := getNetworkServices()
+		allServices, err := getNetworkServices()
 		if err != nil {
 			c.JSON(http.StatusInternalServerError, gin.H{
 				"error": "Failed to get network services: " + err.Error(),
@@ -272,11 +227,63 @@ func (s *Server) setupRoutes() {
 			return
 		}
 
+		services, err := filterServices(pac.Services, allServices)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"error": err.Error(),
+			})
+			return
+		}
+
+		// Native mode evaluates the PAC script ourselves and points services
+		// at an embedded local proxy instead of handing the URL to
+		// networksetup.
+		setProxy := func(service string) error { return setPACProxy(service, validURL) }
+		action := "pac"
+		successVerb := "PAC proxy"
+		if pac.Native {
+			port := pac.Port
+			if port == 0 {
+				port = defaultNativeProxyPort
+			}
+
+			var gfwRefresh time.Duration
+			if pac.GFWRefreshSeconds > 0 {
+				gfwRefresh = time.Duration(pac.GFWRefreshSeconds) * time.Second
+			}
+
+			validGFWURL := pac.GFWListURL
+			if validGFWURL != "" {
+				validGFWURL, err = validatePacURL(validGFWURL)
+				if err != nil {
+					c.JSON(http.StatusBadRequest, gin.H{
+						"error": "Invalid gfw_list_url: " + err.Error(),
+					})
+					return
+				}
+			}
+
+			if err := s.ensureNativeProxy(validURL, port, validGFWURL, gfwRefresh); err != nil {
+				c.JSON(http.StatusInternalServerError, gin.H{
+					"error": "Failed to start native PAC proxy: " + err.Error(),
+				})
+				return
+			}
+
+			setProxy = func(service string) error {
+				return setGlobalProxy(service, "127.0.0.1", strconv.Itoa(port), "")
+			}
+			action = "pac-native"
+			successVerb = "Native PAC proxy"
+		}
+
 		// Set PAC proxy for each service
 		var errorMessages []string
 		var successCount int
 		for _, service := range services {
-			if err := setPACProxy(service, validURL); err != nil {
+			err := setProxy(service)
+			s.events.publishResult(service, action, err)
+			if err != nil {
 				errorMessages = append(errorMessages, "Failed to set PAC proxy for "+service+": "+err.Error())
 			} else {
 				successCount++
@@ -285,10 +292,10 @@ func (s *Server) setupRoutes() {
 
 		if successCount > 0 {
 			if len(errorMessages) > 0 {
-				c.String(200, fmt.Sprintf("PAC proxy set for %d/%d services. Some errors occurred: %s",
-					successCount, len(services), strings.Join(errorMessages, "; ")))
+				c.String(200, fmt.Sprintf("%s set for %d/%d services. Some errors occurred: %s",
+					successVerb, successCount, len(services), strings.Join(errorMessages, "; ")))
 			} else {
-				c.String(200, "PAC proxy has been set for all services")
+				c.String(200, successVerb+" has been set for all services")
 			}
 		} else {
 			c.JSON(http.StatusInternalServerError, gin.H{
@@ -315,7 +322,7 @@ func (s *Server) setupRoutes() {
 			return
 		}
 
-		services, err := getNetworkServices()
+		allServices, err := getNetworkServices()
 		if err != nil {
 			c.JSON(http.StatusInternalServerError, gin.H{
 				"error": "Failed to get network services: " + err.Error(),
@@ -323,11 +330,21 @@ func (s *Server) setupRoutes() {
 			return
 		}
 
+		services, err := filterServices(global.Services, allServices)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"error": err.Error(),
+			})
+			return
+		}
+
 		// Process global proxy settings
 		var errorMessages []string
 		var successCount int
 		for _, service := range services {
-			if err := setGlobalProxy(service, global.HOST, global.PORT, global.BYPASS); err != nil {
+			err := setGlobalProxy(service, global.HOST, global.PORT, global.BYPASS)
+			s.events.publishResult(service, "global", err)
+			if err != nil {
 				errorMessages = append(errorMessages, "Failed to set global proxy for "+service+": "+err.Error())
 			} else {
 				successCount++
@@ -349,7 +366,11 @@ func (s *Server) setupRoutes() {
 	})
 
 	s.engine.GET("/off", func(c *gin.Context) {
-		services, err := getNetworkServices()
+		// Body is optional; absence or a malformed body just means "hard off".
+		var req OffRequest
+		_ = c.ShouldBindJSON(&req)
+
+		allServices, err := getNetworkServices()
 		if err != nil {
 			c.JSON(http.StatusInternalServerError, gin.H{
 				"error": "Failed to get network services: " + err.Error(),
@@ -357,11 +378,48 @@ func (s *Server) setupRoutes() {
 			return
 		}
 
-		// Turn off proxy for each service
+		services, err := filterServices(req.Services, allServices)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"error": err.Error(),
+			})
+			return
+		}
+
+		var snap *proxySnapshot
+		if req.Restore {
+			snap, err = loadSnapshot()
+			if err != nil {
+				c.JSON(http.StatusInternalServerError, gin.H{
+					"error": "Failed to load snapshot: " + err.Error(),
+				})
+				return
+			}
+		}
+
+		// Turn off (or restore) proxy for each service
 		var errorMessages []string
 		var successCount int
 		for _, service := range services {
-			if err := turnOffProxies(service); err != nil {
+			var err error
+			if req.Restore {
+				state, ok := snap.Services[service]
+				if !ok {
+					err = errors.New("no snapshot found for service")
+				} else {
+					err = restoreServiceState(service, state)
+				}
+			} else {
+				err = turnOffProxies(service)
+			}
+
+			action := "off"
+			if req.Restore {
+				action = "restore"
+			}
+			s.events.publishResult(service, action, err)
+
+			if err != nil {
 				errorMessages = append(errorMessages, "Failed to turn off proxy for "+service+": "+err.Error())
 			} else {
 				successCount++
@@ -383,10 +441,94 @@ func (s *Server) setupRoutes() {
 			})
 		}
 	})
+
+	s.engine.POST("/snapshot", func(c *gin.Context) {
+		services, err := getNetworkServices()
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{
+				"error": "Failed to get network services: " + err.Error(),
+			})
+			return
+		}
+
+		snap, err := captureSnapshot(services)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{
+				"error": "Failed to capture snapshot: " + err.Error(),
+			})
+			return
+		}
+
+		c.JSON(http.StatusOK, snap)
+	})
+
+	s.engine.GET("/snapshot", func(c *gin.Context) {
+		snap, err := loadSnapshot()
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{
+				"error": "Failed to load snapshot: " + err.Error(),
+			})
+			return
+		}
+
+		c.JSON(http.StatusOK, snap)
+	})
+
+	s.engine.POST("/restore", func(c *gin.Context) {
+		snap, err := loadSnapshot()
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{
+				"error": "Failed to load snapshot: " + err.Error(),
+			})
+			return
+		}
+
+		if len(snap.Services) == 0 {
+			c.JSON(http.StatusNotFound, gin.H{
+				"error": "No snapshot available to restore",
+			})
+			return
+		}
+
+		var errorMessages []string
+		var successCount int
+		for service, state := range snap.Services {
+			err := restoreServiceState(service, state)
+			s.events.publishResult(service, "restore", err)
+			if err != nil {
+				errorMessages = append(errorMessages, "Failed to restore "+service+": "+err.Error())
+			} else {
+				successCount++
+			}
+		}
+
+		if successCount > 0 {
+			if len(errorMessages) > 0 {
+				c.String(200, fmt.Sprintf("Restored %d/%d services. Some errors occurred: %s",
+					successCount, len(snap.Services), strings.Join(errorMessages, "; ")))
+			} else {
+				c.String(200, "Proxy configuration has been restored for all services")
+			}
+		} else {
+			c.JSON(http.StatusInternalServerError, gin.H{
+				"error": "Failed to restore any service: " + strings.Join(errorMessages, "; "),
+			})
+		}
+	})
+
+	s.engine.GET("/events", s.handleEvents)
+
+	s.engine.GET("/services", s.handleListServices)
+	s.engine.DELETE("/proxy/:service", s.handleDeleteProxy)
 }
 
 // Create initial socket
 func (s *Server) createSocket() error {
+	// Remove existing socket file if exists
+	if err := os.RemoveAll(s.addr); err != nil {
+		return err
+	}
+
 	listener, err := net.Listen("unix", s.addr)
 	if err != nil {
 		return err
@@ -400,23 +542,37 @@ func (s *Server) createSocket() error {
 		return err
 	}
 
-	// Start server in goroutine
+	s.serve(listener)
+
+	return nil
+}
+
+// serve starts s.srv.Serve(listener) in a goroutine.
+func (s *Server) serve(listener net.Listener) {
 	go func() {
 		if err := s.srv.Serve(listener); err != nil && err != http.ErrServerClosed {
 			// Server error, silently continue
 		}
 	}()
-
-	return nil
 }
 
 func (s *Server) Start() error {
 	s.setupRoutes()
 
-	// Remove existing socket file if exists
-	if err := os.RemoveAll(s.addr); err != nil {
+	// Prefer a socket handed to us via systemd/launchd activation; this
+	// gives us correct permissions/ownership from the socket unit and lets
+	// the supervisor control the listen lifecycle.
+	listener, err := activatedListener()
+	if err != nil {
 		return err
 	}
+	if listener != nil {
+		s.listener = listener
+		s.activated = true
+		s.serve(listener)
+		s.startSignalHandler()
+		return nil
+	}
 
 	// Create new socket listener
 	if err := s.createSocket(); err != nil {
@@ -436,6 +592,13 @@ func (s *Server) startSignalHandler() {
 		signal.Notify(sigChan, syscall.SIGUSR1)
 
 		for range sigChan {
+			if s.activated {
+				// We don't own the listening socket; if it's gone there's
+				// nothing for us to recreate. Exit and let the supervisor
+				// re-activate us on the next connection.
+				os.Exit(0)
+			}
+
 			if _, err := os.Stat(s.addr); os.IsNotExist(err) {
 				s.recreateListener()
 			}
@@ -471,7 +634,6 @@ func (s *Server) recreateListener() error {
 		Handler: s.engine,
 	}
 
-	// Start new server goroutine
 	go func() {
 		if err := newSrv.Serve(listener); err != nil && err != http.ErrServerClosed {
 			// Server error, silently continue
@@ -514,5 +676,7 @@ func main() {
 		server.listener.Close()
 	}
 
-	os.RemoveAll(server.addr)
+	if !server.activated {
+		os.RemoveAll(server.addr)
+	}
 }
@@ -0,0 +1,185 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/dop251/goja"
+)
+
+// pacEngine evaluates a PAC (Proxy Auto-Config) script's FindProxyForURL
+// in-process via goja, instead of pushing the PAC URL into networksetup and
+// relying on the OS's own (occasionally unreliable) evaluator.
+type pacEngine struct {
+	mu     sync.RWMutex
+	script string
+
+	httpClient *http.Client
+}
+
+func newPACEngine(pacURL string) (*pacEngine, error) {
+	e := &pacEngine{httpClient: &http.Client{Timeout: 10 * time.Second}}
+	if err := e.Reload(pacURL); err != nil {
+		return nil, err
+	}
+	return e, nil
+}
+
+// Reload re-downloads the PAC script from pacURL and swaps it in.
+func (e *pacEngine) Reload(pacURL string) error {
+	resp, err := e.httpClient.Get(pacURL)
+	if err != nil {
+		return fmt.Errorf("failed to fetch PAC script: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("failed to fetch PAC script: unexpected status %s", resp.Status)
+	}
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return fmt.Errorf("failed to read PAC script: %w", err)
+	}
+
+	e.mu.Lock()
+	e.script = string(body)
+	e.mu.Unlock()
+
+	return nil
+}
+
+// FindProxyForURL evaluates the PAC script's FindProxyForURL(url, host) and
+// returns its raw result string (e.g. "PROXY 1.2.3.4:8080; DIRECT"). A fresh
+// goja.Runtime is used per call so concurrent requests can't race on VM
+// state.
+func (e *pacEngine) FindProxyForURL(rawURL, host string) (string, error) {
+	e.mu.RLock()
+	script := e.script
+	e.mu.RUnlock()
+
+	if script == "" {
+		return "", errors.New("no PAC script loaded")
+	}
+
+	vm := goja.New()
+	registerPACHelpers(vm)
+
+	if _, err := vm.RunString(script); err != nil {
+		return "", fmt.Errorf("failed to evaluate PAC script: %w", err)
+	}
+
+	findProxy, ok := goja.AssertFunction(vm.Get("FindProxyForURL"))
+	if !ok {
+		return "", errors.New("PAC script does not define FindProxyForURL")
+	}
+
+	result, err := findProxy(goja.Undefined(), vm.ToValue(rawURL), vm.ToValue(host))
+	if err != nil {
+		return "", fmt.Errorf("FindProxyForURL failed: %w", err)
+	}
+
+	return result.String(), nil
+}
+
+// registerPACHelpers installs the standard PAC helper functions into vm's
+// global scope, per the Netscape PAC spec.
+func registerPACHelpers(vm *goja.Runtime) {
+	vm.Set("isPlainHostName", pacIsPlainHostName)
+	vm.Set("dnsDomainIs", pacDNSDomainIs)
+	vm.Set("localHostOrDomainIs", pacLocalHostOrDomainIs)
+	vm.Set("isResolvable", pacIsResolvable)
+	vm.Set("isInNet", pacIsInNet)
+	vm.Set("dnsResolve", pacDNSResolve)
+	vm.Set("myIpAddress", pacMyIPAddress)
+	vm.Set("shExpMatch", pacShExpMatch)
+	vm.Set("weekdayRange", pacAlwaysTrue)
+	vm.Set("dateRange", pacAlwaysTrue)
+	vm.Set("timeRange", pacAlwaysTrue)
+	vm.Set("alert", func(string) {})
+}
+
+func pacIsPlainHostName(host string) bool {
+	return !strings.Contains(host, ".") && !strings.Contains(host, ":")
+}
+
+func pacDNSDomainIs(host, domain string) bool {
+	return strings.HasSuffix(host, domain)
+}
+
+func pacLocalHostOrDomainIs(host, hostdom string) bool {
+	if host == hostdom {
+		return true
+	}
+	dot := strings.Index(hostdom, ".")
+	return dot >= 0 && host == hostdom[:dot]
+}
+
+func pacIsResolvable(host string) bool {
+	_, err := net.LookupHost(host)
+	return err == nil
+}
+
+func pacDNSResolve(host string) string {
+	addrs, err := net.LookupHost(host)
+	if err != nil || len(addrs) == 0 {
+		return ""
+	}
+	return addrs[0]
+}
+
+func pacMyIPAddress() string {
+	conn, err := net.Dial("udp", "8.8.8.8:80")
+	if err != nil {
+		return "127.0.0.1"
+	}
+	defer conn.Close()
+	return conn.LocalAddr().(*net.UDPAddr).IP.String()
+}
+
+func pacIsInNet(host, pattern, mask string) bool {
+	resolved := pacDNSResolve(host)
+	if resolved == "" {
+		resolved = host
+	}
+
+	ip := net.ParseIP(resolved).To4()
+	patternIP := net.ParseIP(pattern).To4()
+	maskIP := net.ParseIP(mask).To4()
+	if ip == nil || patternIP == nil || maskIP == nil {
+		return false
+	}
+
+	for i := range ip {
+		if ip[i]&maskIP[i] != patternIP[i]&maskIP[i] {
+			return false
+		}
+	}
+
+	return true
+}
+
+// pacShExpMatch implements the shell-glob matcher (`*` and `?`) PAC scripts
+// use for host/URL matching.
+func pacShExpMatch(str, shExp string) bool {
+	pattern := "^" + regexp.QuoteMeta(shExp) + "$"
+	pattern = strings.ReplaceAll(pattern, `\*`, ".*")
+	pattern = strings.ReplaceAll(pattern, `\?`, ".")
+
+	matched, err := regexp.MatchString(pattern, str)
+	return err == nil && matched
+}
+
+// pacAlwaysTrue backs the date/time-range helpers. Real-world PAC scripts
+// rarely depend on them, and always reporting "in range" is safer than
+// breaking proxy selection over an unimplemented edge case.
+func pacAlwaysTrue(args ...goja.Value) bool {
+	return true
+}
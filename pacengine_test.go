@@ -0,0 +1,77 @@
+package main
+
+import "testing"
+
+func TestPacIsPlainHostName(t *testing.T) {
+	cases := map[string]bool{
+		"www":             true,
+		"www.mozilla.org": false,
+		"1.2.3.4":         false,
+		"host:8080":       false,
+	}
+	for host, want := range cases {
+		if got := pacIsPlainHostName(host); got != want {
+			t.Errorf("pacIsPlainHostName(%q) = %v, want %v", host, got, want)
+		}
+	}
+}
+
+func TestPacDNSDomainIs(t *testing.T) {
+	if !pacDNSDomainIs("www.mozilla.org", ".mozilla.org") {
+		t.Error("expected www.mozilla.org to match .mozilla.org")
+	}
+	if pacDNSDomainIs("www.example.com", ".mozilla.org") {
+		t.Error("expected www.example.com to not match .mozilla.org")
+	}
+}
+
+func TestPacLocalHostOrDomainIs(t *testing.T) {
+	if !pacLocalHostOrDomainIs("www.mozilla.org", "www.mozilla.org") {
+		t.Error("expected exact match to succeed")
+	}
+	if !pacLocalHostOrDomainIs("www", "www.mozilla.org") {
+		t.Error("expected bare hostname to match the hostdom's hostname part")
+	}
+	if pacLocalHostOrDomainIs("other", "www.mozilla.org") {
+		t.Error("expected mismatched hostname to fail")
+	}
+}
+
+func TestPacShExpMatch(t *testing.T) {
+	cases := []struct {
+		str, pattern string
+		want         bool
+	}{
+		{"http://www.example.com/path", "*/path", true},
+		{"www.example.com", "*.example.com", true},
+		{"www.example.org", "*.example.com", false},
+		{"abc", "a?c", true},
+		{"abc", "a?d", false},
+	}
+	for _, tc := range cases {
+		if got := pacShExpMatch(tc.str, tc.pattern); got != tc.want {
+			t.Errorf("pacShExpMatch(%q, %q) = %v, want %v", tc.str, tc.pattern, got, tc.want)
+		}
+	}
+}
+
+func TestPacIsInNet(t *testing.T) {
+	if !pacIsInNet("192.168.1.10", "192.168.1.0", "255.255.255.0") {
+		t.Error("expected 192.168.1.10 to be in 192.168.1.0/255.255.255.0")
+	}
+	if pacIsInNet("192.168.2.10", "192.168.1.0", "255.255.255.0") {
+		t.Error("expected 192.168.2.10 to not be in 192.168.1.0/255.255.255.0")
+	}
+}
+
+func TestPacIsInNetInvalidIP(t *testing.T) {
+	if pacIsInNet("not-an-ip-or-host", "192.168.1.0", "255.255.255.0") {
+		t.Error("expected an unresolvable host to not match any net")
+	}
+}
+
+func TestPacAlwaysTrue(t *testing.T) {
+	if !pacAlwaysTrue() {
+		t.Error("expected pacAlwaysTrue to always return true")
+	}
+}
@@ -0,0 +1,30 @@
+//go:build !(darwin && cgo)
+
+package main
+
+// Without a cgo-enabled darwin build we can't link SystemConfiguration.framework,
+// so the networksetup CLI (proxybackend_exec.go) is the only backend available.
+
+func getNetworkServicesBackend() ([]string, error) {
+	return execGetNetworkServices()
+}
+
+func turnOffProxiesBackend(service string) error {
+	return execTurnOffProxies(service)
+}
+
+func applyPACProxyBackend(service, pacURL string) error {
+	return execApplyPACProxy(service, pacURL)
+}
+
+func applyGlobalProxyBackend(service, host, port, bypass string) error {
+	return execApplyGlobalProxy(service, host, port, bypass)
+}
+
+func captureServiceStateBackend(service string) (serviceProxyState, error) {
+	return execCaptureServiceState(service)
+}
+
+func restoreServiceStateBackend(service string, state serviceProxyState) error {
+	return execRestoreServiceState(service, state)
+}
@@ -0,0 +1,294 @@
+package main
+
+import (
+	"errors"
+	"os/exec"
+	"strings"
+)
+
+// This file holds the networksetup-based backend: it shells out to the
+// `networksetup` CLI once per key per service. It's always compiled (no
+// build tag) so it can double as the runtime fallback for the
+// SystemConfiguration-backed implementation in proxybackend_sc_darwin.go
+// when that one lacks the entitlements it needs.
+
+// execGetNetworkServices lists active network services via
+// `networksetup -listnetworkserviceorder`.
+func execGetNetworkServices() ([]string, error) {
+	cmd := exec.Command("networksetup", "-listnetworkserviceorder")
+	output, err := cmd.Output()
+	if err != nil {
+		return nil, err
+	}
+
+	lines := strings.Split(string(output), "\n")
+	var services []string
+
+	for _, line := range lines {
+
+		// Skip hardware port lines like "(Hardware Port: ..., Device: ...)"
+		if strings.HasPrefix(line, "(") && strings.Contains(line, ")") && !strings.Contains(line, "Hardware Port:") {
+
+			parts := strings.SplitN(line, ") ", 2)
+			if len(parts) == 2 {
+				service := strings.TrimSpace(parts[1])
+				if service != "" && service != "*" {
+
+					service = strings.TrimPrefix(service, "*")
+					service = strings.TrimSpace(service)
+					services = append(services, service)
+				}
+			}
+		}
+	}
+
+	if len(services) == 0 {
+		return nil, errors.New("no network services found")
+	}
+
+	return services, nil
+}
+
+// execTurnOffProxies disables every proxy type for service, one
+// `networksetup` invocation per key.
+func execTurnOffProxies(service string) error {
+	commands := [][]string{
+		{"networksetup", "-setautoproxystate", service, "off"},
+		{"networksetup", "-setproxyautodiscovery", service, "off"},
+		{"networksetup", "-setwebproxystate", service, "off"},
+		{"networksetup", "-setsecurewebproxystate", service, "off"},
+		{"networksetup", "-setsocksfirewallproxystate", service, "off"},
+	}
+
+	for _, args := range commands {
+		cmd := exec.Command(args[0], args[1:]...)
+		if err := cmd.Run(); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// execApplyPACProxy sets and enables a PAC URL for service. Callers are
+// expected to have already turned the service's proxies off.
+func execApplyPACProxy(service, pacURL string) error {
+	cmd1 := exec.Command("networksetup", "-setautoproxyurl", service, pacURL)
+	if err := cmd1.Run(); err != nil {
+		return err
+	}
+
+	cmd2 := exec.Command("networksetup", "-setautoproxystate", service, "on")
+	if err := cmd2.Run(); err != nil {
+		return err
+	}
+
+	cmd3 := exec.Command("networksetup", "-setproxyautodiscovery", service, "on")
+	if err := cmd3.Run(); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// execApplyGlobalProxy sets and enables a fixed host:port for web/secure-web/
+// SOCKS traffic, with an optional bypass list. Callers are expected to have
+// already turned the service's proxies off.
+func execApplyGlobalProxy(service, host, port, bypass string) error {
+	commands := [][]string{
+		{"networksetup", "-setwebproxy", service, host, port},
+		{"networksetup", "-setsecurewebproxy", service, host, port},
+		{"networksetup", "-setsocksfirewallproxy", service, host, port},
+	}
+
+	for _, args := range commands {
+		cmd := exec.Command(args[0], args[1:]...)
+		if err := cmd.Run(); err != nil {
+			return err
+		}
+	}
+
+	if domains := splitBypassDomains(bypass); len(domains) > 0 {
+		args := []string{"-setproxybypassdomains", service}
+		args = append(args, domains...)
+		cmd := exec.Command("networksetup", args...)
+		if err := cmd.Run(); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// execCaptureServiceState shells out to networksetup to read back everything
+// we might later need to restore for service.
+func execCaptureServiceState(service string) (serviceProxyState, error) {
+	autoProxy, err := execGetAutoProxyState(service)
+	if err != nil {
+		return serviceProxyState{}, err
+	}
+
+	webProxy, err := execGetWebProxyState("-getwebproxy", service)
+	if err != nil {
+		return serviceProxyState{}, err
+	}
+
+	secureWebProxy, err := execGetWebProxyState("-getsecurewebproxy", service)
+	if err != nil {
+		return serviceProxyState{}, err
+	}
+
+	socksProxy, err := execGetWebProxyState("-getsocksfirewallproxy", service)
+	if err != nil {
+		return serviceProxyState{}, err
+	}
+
+	bypassDomains, err := execGetBypassDomains(service)
+	if err != nil {
+		return serviceProxyState{}, err
+	}
+
+	autoDiscovery, err := execGetAutoDiscovery(service)
+	if err != nil {
+		return serviceProxyState{}, err
+	}
+
+	return serviceProxyState{
+		AutoProxy:      autoProxy,
+		WebProxy:       webProxy,
+		SecureWebProxy: secureWebProxy,
+		SocksProxy:     socksProxy,
+		BypassDomains:  bypassDomains,
+		AutoDiscovery:  autoDiscovery,
+	}, nil
+}
+
+func execGetAutoProxyState(service string) (autoProxyState, error) {
+	kv, err := execRunNetworksetupKV("-getautoproxyurl", service)
+	if err != nil {
+		return autoProxyState{}, err
+	}
+
+	return autoProxyState{
+		URL:     kv["URL"],
+		Enabled: kv["Enabled"] == "Yes",
+	}, nil
+}
+
+func execGetWebProxyState(flag, service string) (webProxyState, error) {
+	kv, err := execRunNetworksetupKV(flag, service)
+	if err != nil {
+		return webProxyState{}, err
+	}
+
+	return webProxyState{
+		Enabled: kv["Enabled"] == "Yes",
+		Server:  kv["Server"],
+		Port:    kv["Port"],
+	}, nil
+}
+
+func execGetBypassDomains(service string) ([]string, error) {
+	cmd := exec.Command("networksetup", "-getproxybypassdomains", service)
+	output, err := cmd.Output()
+	if err != nil {
+		return nil, err
+	}
+
+	var domains []string
+	for _, line := range strings.Split(string(output), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || line == "There are no bypass domains set." {
+			continue
+		}
+		domains = append(domains, line)
+	}
+
+	return domains, nil
+}
+
+func execGetAutoDiscovery(service string) (bool, error) {
+	kv, err := execRunNetworksetupKV("-getproxyautodiscovery", service)
+	if err != nil {
+		return false, err
+	}
+
+	return kv["Auto Proxy Discovery"] == "Yes", nil
+}
+
+// execRunNetworksetupKV runs `networksetup <flag> <service>` and parses its
+// "Key: Value" line output into a map.
+func execRunNetworksetupKV(flag, service string) (map[string]string, error) {
+	cmd := exec.Command("networksetup", flag, service)
+	output, err := cmd.Output()
+	if err != nil {
+		return nil, err
+	}
+
+	kv := make(map[string]string)
+	for _, line := range strings.Split(string(output), "\n") {
+		parts := strings.SplitN(line, ":", 2)
+		if len(parts) != 2 {
+			continue
+		}
+		kv[strings.TrimSpace(parts[0])] = strings.TrimSpace(parts[1])
+	}
+
+	return kv, nil
+}
+
+// execRestoreServiceState re-applies a previously captured proxy
+// configuration to service. Callers are expected to have already turned the
+// service's proxies off.
+func execRestoreServiceState(service string, state serviceProxyState) error {
+	if state.AutoProxy.URL != "" {
+		if err := exec.Command("networksetup", "-setautoproxyurl", service, state.AutoProxy.URL).Run(); err != nil {
+			return err
+		}
+	}
+	if err := execSetProxyOnOff("-setautoproxystate", service, state.AutoProxy.Enabled); err != nil {
+		return err
+	}
+
+	if err := execRestoreWebProxy("-setwebproxy", "-setwebproxystate", service, state.WebProxy); err != nil {
+		return err
+	}
+	if err := execRestoreWebProxy("-setsecurewebproxy", "-setsecurewebproxystate", service, state.SecureWebProxy); err != nil {
+		return err
+	}
+	if err := execRestoreWebProxy("-setsocksfirewallproxy", "-setsocksfirewallproxystate", service, state.SocksProxy); err != nil {
+		return err
+	}
+
+	if len(state.BypassDomains) > 0 {
+		args := append([]string{"-setproxybypassdomains", service}, state.BypassDomains...)
+		if err := exec.Command("networksetup", args...).Run(); err != nil {
+			return err
+		}
+	}
+
+	return execSetProxyOnOff("-setproxyautodiscovery", service, state.AutoDiscovery)
+}
+
+func execRestoreWebProxy(setFlag, stateFlag, service string, state webProxyState) error {
+	if state.Server != "" {
+		port := state.Port
+		if port == "" {
+			port = "0"
+		}
+		if err := exec.Command("networksetup", setFlag, service, state.Server, port).Run(); err != nil {
+			return err
+		}
+	}
+
+	return execSetProxyOnOff(stateFlag, service, state.Enabled)
+}
+
+func execSetProxyOnOff(flag, service string, enabled bool) error {
+	value := "off"
+	if enabled {
+		value = "on"
+	}
+
+	return exec.Command("networksetup", flag, service, value).Run()
+}
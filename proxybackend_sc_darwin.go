@@ -0,0 +1,420 @@
+//go:build darwin && cgo
+
+package main
+
+/*
+#cgo LDFLAGS: -framework SystemConfiguration -framework CoreFoundation -framework Security
+#include <CoreFoundation/CoreFoundation.h>
+#include <SystemConfiguration/SystemConfiguration.h>
+#include <Security/Authorization.h>
+#include <stdlib.h>
+
+static SCPreferencesRef APH_CreatePreferences(CFStringRef name) {
+	return SCPreferencesCreateWithAuthorization(kCFAllocatorDefault, name, NULL, NULL);
+}
+*/
+import "C"
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"unsafe"
+)
+
+// This file implements the same turnOffProxies/setPACProxy/setGlobalProxy/
+// getNetworkServices contract as proxybackend_exec.go, but talks to
+// SystemConfiguration.framework directly via cgo instead of forking
+// `networksetup` once per key per service. Every key for a
+// given service is written into a single CFDictionary and committed in one
+// SCPreferencesCommitChanges/ApplyChanges pair, so an intermediate failure
+// can no longer leave a service half-configured.
+//
+// SCPreferencesCreateWithAuthorization requires entitlements we may not
+// have (e.g. when not running as the helper's installed, signed binary);
+// when it fails, every function below falls back to the exec* helpers in
+// proxybackend_exec.go.
+
+// errSCUnavailable marks that SCPreferencesCreateWithAuthorization failed,
+// signalling callers to fall back to the networksetup CLI.
+var errSCUnavailable = errors.New("SystemConfiguration authorization unavailable")
+
+func getNetworkServicesBackend() ([]string, error) {
+	names, err := scListServiceNames()
+	if errors.Is(err, errSCUnavailable) {
+		return execGetNetworkServices()
+	}
+	return names, err
+}
+
+func turnOffProxiesBackend(service string) error {
+	err := scApplyProxyConfig(service, scProxyConfig{})
+	if errors.Is(err, errSCUnavailable) {
+		return execTurnOffProxies(service)
+	}
+	return err
+}
+
+func applyPACProxyBackend(service, pacURL string) error {
+	err := scApplyProxyConfig(service, scProxyConfig{
+		autoConfigEnabled: true,
+		autoConfigURL:     pacURL,
+		autoDiscovery:     true,
+	})
+	if errors.Is(err, errSCUnavailable) {
+		return execApplyPACProxy(service, pacURL)
+	}
+	return err
+}
+
+func applyGlobalProxyBackend(service, host, port, bypass string) error {
+	cfg := scProxyConfig{
+		httpEnabled: true, httpHost: host, httpPort: port,
+		httpsEnabled: true, httpsHost: host, httpsPort: port,
+		socksEnabled: true, socksHost: host, socksPort: port,
+		exceptions: splitBypassDomains(bypass),
+	}
+
+	err := scApplyProxyConfig(service, cfg)
+	if errors.Is(err, errSCUnavailable) {
+		return execApplyGlobalProxy(service, host, port, bypass)
+	}
+	return err
+}
+
+func captureServiceStateBackend(service string) (serviceProxyState, error) {
+	state, err := scCaptureServiceState(service)
+	if errors.Is(err, errSCUnavailable) {
+		return execCaptureServiceState(service)
+	}
+	return state, err
+}
+
+func restoreServiceStateBackend(service string, state serviceProxyState) error {
+	err := scRestoreServiceState(service, state)
+	if errors.Is(err, errSCUnavailable) {
+		return execRestoreServiceState(service, state)
+	}
+	return err
+}
+
+// scCaptureServiceState reads service's entire Proxies dictionary back out
+// of preferences in one pass, mirroring the keys toCFDictionary writes.
+func scCaptureServiceState(service string) (serviceProxyState, error) {
+	prefs, err := scOpenPreferences()
+	if err != nil {
+		return serviceProxyState{}, err
+	}
+	defer C.CFRelease(C.CFTypeRef(prefs))
+
+	serviceID, err := scFindServiceID(prefs, service)
+	if err != nil {
+		return serviceProxyState{}, err
+	}
+
+	path := cfString(fmt.Sprintf("/NetworkServices/%s/Proxies", serviceID))
+	defer C.CFRelease(C.CFTypeRef(path))
+
+	value := C.SCPreferencesPathGetValue(prefs, path)
+	if value == 0 {
+		// Nothing has ever been written for this service; every proxy type
+		// defaults to disabled.
+		return serviceProxyState{}, nil
+	}
+	dict := C.CFDictionaryRef(value)
+
+	getBool := func(key C.CFStringRef) bool {
+		v := C.CFDictionaryGetValue(dict, unsafe.Pointer(key))
+		if v == nil {
+			return false
+		}
+		return C.CFBooleanGetValue(C.CFBooleanRef(v)) != 0
+	}
+	getString := func(key C.CFStringRef) string {
+		v := C.CFDictionaryGetValue(dict, unsafe.Pointer(key))
+		if v == nil {
+			return ""
+		}
+		return cfStringToGo(C.CFStringRef(v))
+	}
+	getPort := func(key C.CFStringRef) string {
+		v := C.CFDictionaryGetValue(dict, unsafe.Pointer(key))
+		if v == nil {
+			return ""
+		}
+		var port C.int
+		C.CFNumberGetValue(C.CFNumberRef(v), C.kCFNumberIntType, unsafe.Pointer(&port))
+		return strconv.Itoa(int(port))
+	}
+	getStringArray := func(key C.CFStringRef) []string {
+		v := C.CFDictionaryGetValue(dict, unsafe.Pointer(key))
+		if v == nil {
+			return nil
+		}
+		arr := C.CFArrayRef(v)
+		count := C.CFArrayGetCount(arr)
+		var out []string
+		for i := C.CFIndex(0); i < count; i++ {
+			out = append(out, cfStringToGo(C.CFStringRef(C.CFArrayGetValueAtIndex(arr, i))))
+		}
+		return out
+	}
+
+	return serviceProxyState{
+		AutoProxy: autoProxyState{
+			URL:     getString(C.kSCPropNetProxiesProxyAutoConfigURLString),
+			Enabled: getBool(C.kSCPropNetProxiesProxyAutoConfigEnable),
+		},
+		WebProxy: webProxyState{
+			Enabled: getBool(C.kSCPropNetProxiesHTTPEnable),
+			Server:  getString(C.kSCPropNetProxiesHTTPProxy),
+			Port:    getPort(C.kSCPropNetProxiesHTTPPort),
+		},
+		SecureWebProxy: webProxyState{
+			Enabled: getBool(C.kSCPropNetProxiesHTTPSEnable),
+			Server:  getString(C.kSCPropNetProxiesHTTPSProxy),
+			Port:    getPort(C.kSCPropNetProxiesHTTPSPort),
+		},
+		SocksProxy: webProxyState{
+			Enabled: getBool(C.kSCPropNetProxiesSOCKSEnable),
+			Server:  getString(C.kSCPropNetProxiesSOCKSProxy),
+			Port:    getPort(C.kSCPropNetProxiesSOCKSPort),
+		},
+		BypassDomains: getStringArray(C.kSCPropNetProxiesExceptionsList),
+		AutoDiscovery: getBool(C.kSCPropNetProxiesProxyAutoDiscoveryEnable),
+	}, nil
+}
+
+// scRestoreServiceState re-applies a previously captured proxy configuration
+// to service in the same single-transaction way scApplyProxyConfig always
+// has, instead of the exec backend's one-networksetup-call-per-key restore.
+func scRestoreServiceState(service string, state serviceProxyState) error {
+	cfg := scProxyConfig{
+		httpEnabled: state.WebProxy.Enabled, httpHost: state.WebProxy.Server, httpPort: state.WebProxy.Port,
+		httpsEnabled: state.SecureWebProxy.Enabled, httpsHost: state.SecureWebProxy.Server, httpsPort: state.SecureWebProxy.Port,
+		socksEnabled: state.SocksProxy.Enabled, socksHost: state.SocksProxy.Server, socksPort: state.SocksProxy.Port,
+
+		autoConfigEnabled: state.AutoProxy.Enabled,
+		autoConfigURL:     state.AutoProxy.URL,
+		autoDiscovery:     state.AutoDiscovery,
+
+		exceptions: state.BypassDomains,
+	}
+
+	return scApplyProxyConfig(service, cfg)
+}
+
+// scProxyConfig is the Go-side view of everything we can write into a
+// service's "/NetworkServices/<id>/Proxies" preferences path.
+type scProxyConfig struct {
+	httpEnabled, httpsEnabled, socksEnabled bool
+	httpHost, httpsHost, socksHost          string
+	httpPort, httpsPort, socksPort          string
+
+	autoConfigEnabled, autoDiscovery bool
+	autoConfigURL                    string
+
+	exceptions []string
+}
+
+// scOpenPreferences acquires an authorized SCPreferences session for this
+// tool, or errSCUnavailable if we lack the entitlements to do so.
+func scOpenPreferences() (C.SCPreferencesRef, error) {
+	name := cfString("ape-party-helper")
+	defer C.CFRelease(C.CFTypeRef(name))
+
+	prefs := C.APH_CreatePreferences(name)
+	if prefs == 0 {
+		return 0, errSCUnavailable
+	}
+
+	return prefs, nil
+}
+
+// scListServiceNames returns the name of every enabled network service.
+func scListServiceNames() ([]string, error) {
+	prefs, err := scOpenPreferences()
+	if err != nil {
+		return nil, err
+	}
+	defer C.CFRelease(C.CFTypeRef(prefs))
+
+	services := C.SCNetworkServiceCopyAll(prefs)
+	if services == 0 {
+		return nil, errors.New("failed to enumerate network services")
+	}
+	defer C.CFRelease(C.CFTypeRef(services))
+
+	count := C.CFArrayGetCount(services)
+	var names []string
+	for i := C.CFIndex(0); i < count; i++ {
+		svc := C.SCNetworkServiceRef(C.CFArrayGetValueAtIndex(services, i))
+		if C.SCNetworkServiceGetEnabled(svc) == 0 {
+			continue
+		}
+
+		name := C.SCNetworkServiceGetName(svc)
+		if name == 0 {
+			continue
+		}
+		names = append(names, cfStringToGo(name))
+	}
+
+	if len(names) == 0 {
+		return nil, errors.New("no network services found")
+	}
+
+	return names, nil
+}
+
+// scFindServiceID returns the SCNetworkService identifier for the service
+// named name, which is what "/NetworkServices/<id>/Proxies" paths key on.
+func scFindServiceID(prefs C.SCPreferencesRef, name string) (string, error) {
+	services := C.SCNetworkServiceCopyAll(prefs)
+	if services == 0 {
+		return "", errors.New("failed to enumerate network services")
+	}
+	defer C.CFRelease(C.CFTypeRef(services))
+
+	count := C.CFArrayGetCount(services)
+	for i := C.CFIndex(0); i < count; i++ {
+		svc := C.SCNetworkServiceRef(C.CFArrayGetValueAtIndex(services, i))
+		svcName := C.SCNetworkServiceGetName(svc)
+		if svcName != 0 && cfStringToGo(svcName) == name {
+			id := C.SCNetworkServiceGetServiceID(svc)
+			return cfStringToGo(id), nil
+		}
+	}
+
+	return "", fmt.Errorf("network service %q not found", name)
+}
+
+// scApplyProxyConfig stages cfg as service's entire Proxies dictionary and
+// commits it in a single transaction.
+func scApplyProxyConfig(service string, cfg scProxyConfig) error {
+	prefs, err := scOpenPreferences()
+	if err != nil {
+		return err
+	}
+	defer C.CFRelease(C.CFTypeRef(prefs))
+
+	serviceID, err := scFindServiceID(prefs, service)
+	if err != nil {
+		return err
+	}
+
+	path := cfString(fmt.Sprintf("/NetworkServices/%s/Proxies", serviceID))
+	defer C.CFRelease(C.CFTypeRef(path))
+
+	dict := cfg.toCFDictionary()
+	defer C.CFRelease(C.CFTypeRef(dict))
+
+	if C.SCPreferencesPathSetValue(prefs, path, dict) == 0 {
+		return errors.New("failed to stage proxy configuration")
+	}
+	if C.SCPreferencesCommitChanges(prefs) == 0 {
+		return errors.New("failed to commit proxy configuration")
+	}
+	if C.SCPreferencesApplyChanges(prefs) == 0 {
+		return errors.New("failed to apply proxy configuration")
+	}
+
+	return nil
+}
+
+// toCFDictionary builds the CFDictionary SCPreferencesPathSetValue expects,
+// covering every key the networksetup backend would otherwise have set one
+// command at a time.
+func (cfg scProxyConfig) toCFDictionary() C.CFDictionaryRef {
+	dict := C.CFDictionaryCreateMutable(C.kCFAllocatorDefault, 0, &C.kCFTypeDictionaryKeyCallBacks, &C.kCFTypeDictionaryValueCallBacks)
+
+	setBool := func(key C.CFStringRef, value bool) {
+		v := C.CFBooleanRef(C.kCFBooleanFalse)
+		if value {
+			v = C.kCFBooleanTrue
+		}
+		C.CFDictionarySetValue(dict, unsafe.Pointer(key), unsafe.Pointer(v))
+	}
+	setString := func(key C.CFStringRef, value string) {
+		if value == "" {
+			return
+		}
+		cfVal := cfString(value)
+		defer C.CFRelease(C.CFTypeRef(cfVal))
+		C.CFDictionarySetValue(dict, unsafe.Pointer(key), unsafe.Pointer(cfVal))
+	}
+	setPort := func(key C.CFStringRef, value string) {
+		port, err := strconv.Atoi(value)
+		if err != nil {
+			return
+		}
+		cPort := C.int(port)
+		num := C.CFNumberCreate(C.kCFAllocatorDefault, C.kCFNumberIntType, unsafe.Pointer(&cPort))
+		defer C.CFRelease(C.CFTypeRef(num))
+		C.CFDictionarySetValue(dict, unsafe.Pointer(key), unsafe.Pointer(num))
+	}
+
+	setBool(C.kSCPropNetProxiesHTTPEnable, cfg.httpEnabled)
+	setString(C.kSCPropNetProxiesHTTPProxy, cfg.httpHost)
+	setPort(C.kSCPropNetProxiesHTTPPort, cfg.httpPort)
+
+	setBool(C.kSCPropNetProxiesHTTPSEnable, cfg.httpsEnabled)
+	setString(C.kSCPropNetProxiesHTTPSProxy, cfg.httpsHost)
+	setPort(C.kSCPropNetProxiesHTTPSPort, cfg.httpsPort)
+
+	setBool(C.kSCPropNetProxiesSOCKSEnable, cfg.socksEnabled)
+	setString(C.kSCPropNetProxiesSOCKSProxy, cfg.socksHost)
+	setPort(C.kSCPropNetProxiesSOCKSPort, cfg.socksPort)
+
+	setBool(C.kSCPropNetProxiesProxyAutoConfigEnable, cfg.autoConfigEnabled)
+	setString(C.kSCPropNetProxiesProxyAutoConfigURLString, cfg.autoConfigURL)
+	setBool(C.kSCPropNetProxiesProxyAutoDiscoveryEnable, cfg.autoDiscovery)
+
+	if len(cfg.exceptions) > 0 {
+		exceptions := cfStringArray(cfg.exceptions)
+		defer C.CFRelease(C.CFTypeRef(exceptions))
+		C.CFDictionarySetValue(dict, unsafe.Pointer(C.kSCPropNetProxiesExceptionsList), unsafe.Pointer(exceptions))
+	}
+
+	return C.CFDictionaryRef(dict)
+}
+
+// cfString creates a CFStringRef from a Go string. The caller owns the
+// returned reference and must CFRelease it.
+func cfString(s string) C.CFStringRef {
+	cstr := C.CString(s)
+	defer C.free(unsafe.Pointer(cstr))
+	return C.CFStringCreateWithCString(C.kCFAllocatorDefault, cstr, C.kCFStringEncodingUTF8)
+}
+
+// cfStringArray creates a CFArrayRef of CFStringRefs from values. The
+// caller owns the returned reference and must CFRelease it.
+func cfStringArray(values []string) C.CFArrayRef {
+	refs := make([]C.CFStringRef, len(values))
+	for i, v := range values {
+		refs[i] = cfString(v)
+	}
+	defer func() {
+		for _, ref := range refs {
+			C.CFRelease(C.CFTypeRef(ref))
+		}
+	}()
+
+	return C.CFArrayCreate(C.kCFAllocatorDefault, (*unsafe.Pointer)(unsafe.Pointer(&refs[0])), C.CFIndex(len(refs)), &C.kCFTypeArrayCallBacks)
+}
+
+// cfStringToGo converts a CFStringRef to a Go string.
+func cfStringToGo(s C.CFStringRef) string {
+	length := C.CFStringGetLength(s)
+	if length == 0 {
+		return ""
+	}
+
+	maxBytes := C.CFStringGetMaximumSizeForEncoding(length, C.kCFStringEncodingUTF8) + 1
+	buf := make([]byte, int(maxBytes))
+	if C.CFStringGetCString(s, (*C.char)(unsafe.Pointer(&buf[0])), maxBytes, C.kCFStringEncodingUTF8) == 0 {
+		return ""
+	}
+
+	return C.GoString((*C.char)(unsafe.Pointer(&buf[0])))
+}
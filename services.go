@@ -0,0 +1,99 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// filterServices validates requested against discovered and returns it; an
+// empty requested list means "all services", preserving prior behavior.
+func filterServices(requested, discovered []string) ([]string, error) {
+	if len(requested) == 0 {
+		return discovered, nil
+	}
+
+	discoveredSet := make(map[string]struct{}, len(discovered))
+	for _, service := range discovered {
+		discoveredSet[service] = struct{}{}
+	}
+
+	var unknown []string
+	for _, service := range requested {
+		if _, ok := discoveredSet[service]; !ok {
+			unknown = append(unknown, service)
+		}
+	}
+	if len(unknown) > 0 {
+		return nil, fmt.Errorf("unknown service(s): %s", strings.Join(unknown, ", "))
+	}
+
+	return requested, nil
+}
+
+// serviceInfo is returned by GET /services: a discovered network service
+// paired with its current proxy configuration.
+type serviceInfo struct {
+	Name  string            `json:"name"`
+	State serviceProxyState `json:"state"`
+}
+
+// handleListServices reports every discovered network service along with
+// its current proxy state.
+func (s *Server) handleListServices(c *gin.Context) {
+	services, err := getNetworkServices()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": "Failed to get network services: " + err.Error(),
+		})
+		return
+	}
+
+	infos := make([]serviceInfo, 0, len(services))
+	for _, service := range services {
+		state, err := captureServiceState(service)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{
+				"error": "Failed to read state for " + service + ": " + err.Error(),
+			})
+			return
+		}
+		infos = append(infos, serviceInfo{Name: service, State: state})
+	}
+
+	c.JSON(http.StatusOK, infos)
+}
+
+// handleDeleteProxy turns off proxies for exactly the service named by the
+// :service path parameter, leaving every other service untouched.
+func (s *Server) handleDeleteProxy(c *gin.Context) {
+	service := c.Param("service")
+
+	allServices, err := getNetworkServices()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": "Failed to get network services: " + err.Error(),
+		})
+		return
+	}
+
+	if _, err := filterServices([]string{service}, allServices); err != nil {
+		c.JSON(http.StatusNotFound, gin.H{
+			"error": err.Error(),
+		})
+		return
+	}
+
+	err = turnOffProxies(service)
+	s.events.publishResult(service, "off", err)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": "Failed to turn off proxy for " + service + ": " + err.Error(),
+		})
+		return
+	}
+
+	c.String(http.StatusOK, "Proxy has been turned off for "+service)
+}
@@ -0,0 +1,36 @@
+package main
+
+import "testing"
+
+func TestFilterServicesEmptyRequestedMeansAll(t *testing.T) {
+	discovered := []string{"Wi-Fi", "Ethernet"}
+
+	got, err := filterServices(nil, discovered)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(got) != len(discovered) || got[0] != discovered[0] || got[1] != discovered[1] {
+		t.Fatalf("expected %v, got %v", discovered, got)
+	}
+}
+
+func TestFilterServicesSubset(t *testing.T) {
+	discovered := []string{"Wi-Fi", "Ethernet", "Thunderbolt Bridge"}
+
+	got, err := filterServices([]string{"Ethernet"}, discovered)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(got) != 1 || got[0] != "Ethernet" {
+		t.Fatalf("expected [Ethernet], got %v", got)
+	}
+}
+
+func TestFilterServicesUnknownService(t *testing.T) {
+	discovered := []string{"Wi-Fi", "Ethernet"}
+
+	_, err := filterServices([]string{"Wi-Fi", "Bogus"}, discovered)
+	if err == nil {
+		t.Fatal("expected an error for an unknown service")
+	}
+}
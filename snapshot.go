@@ -0,0 +1,185 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// autoProxyState mirrors the output of `networksetup -getautoproxyurl`.
+type autoProxyState struct {
+	URL     string `json:"url"`
+	Enabled bool   `json:"enabled"`
+}
+
+// webProxyState mirrors the output of `networksetup -getwebproxy` and its
+// secure/socks counterparts.
+type webProxyState struct {
+	Enabled bool   `json:"enabled"`
+	Server  string `json:"server"`
+	Port    string `json:"port"`
+}
+
+// serviceProxyState is the full proxy configuration captured for a single
+// network service before we mutate it.
+type serviceProxyState struct {
+	AutoProxy      autoProxyState `json:"auto_proxy"`
+	WebProxy       webProxyState  `json:"web_proxy"`
+	SecureWebProxy webProxyState  `json:"secure_web_proxy"`
+	SocksProxy     webProxyState  `json:"socks_proxy"`
+	BypassDomains  []string       `json:"bypass_domains"`
+	AutoDiscovery  bool           `json:"auto_discovery"`
+}
+
+// proxySnapshot is the on-disk representation of everything we've captured
+// so far, keyed by network service name (e.g. "Wi-Fi").
+type proxySnapshot struct {
+	Services map[string]serviceProxyState `json:"services"`
+	SavedAt  time.Time                    `json:"saved_at"`
+}
+
+// snapshotMu serializes all reads/writes of the snapshot file so concurrent
+// requests don't clobber each other.
+var snapshotMu sync.Mutex
+
+// stateFilePath returns where the snapshot is persisted, preferring
+// $XDG_STATE_HOME and falling back to the macOS Application Support
+// directory.
+func stateFilePath() (string, error) {
+	if dir := os.Getenv("XDG_STATE_HOME"); dir != "" {
+		return filepath.Join(dir, "ape-party-helper", "state.json"), nil
+	}
+
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return "", err
+	}
+
+	return filepath.Join(home, "Library", "Application Support", "ape-party-helper", "state.json"), nil
+}
+
+// loadSnapshot reads the persisted snapshot, returning an empty one if none
+// exists yet.
+func loadSnapshot() (*proxySnapshot, error) {
+	snapshotMu.Lock()
+	defer snapshotMu.Unlock()
+
+	return loadSnapshotLocked()
+}
+
+func loadSnapshotLocked() (*proxySnapshot, error) {
+	path, err := stateFilePath()
+	if err != nil {
+		return nil, err
+	}
+
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return &proxySnapshot{Services: map[string]serviceProxyState{}}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var snap proxySnapshot
+	if err := json.Unmarshal(data, &snap); err != nil {
+		return nil, err
+	}
+	if snap.Services == nil {
+		snap.Services = map[string]serviceProxyState{}
+	}
+
+	return &snap, nil
+}
+
+func saveSnapshotLocked(snap *proxySnapshot) error {
+	path, err := stateFilePath()
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return err
+	}
+
+	data, err := json.MarshalIndent(snap, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	return os.WriteFile(path, data, 0600)
+}
+
+// ensureSnapshot captures and persists service's current proxy configuration
+// the first time it's about to be mutated. It's a no-op if service already
+// has a captured state, so it's safe to call on every mutation.
+func ensureSnapshot(service string) error {
+	snapshotMu.Lock()
+	defer snapshotMu.Unlock()
+
+	snap, err := loadSnapshotLocked()
+	if err != nil {
+		return err
+	}
+	if _, ok := snap.Services[service]; ok {
+		return nil
+	}
+
+	state, err := captureServiceState(service)
+	if err != nil {
+		return err
+	}
+
+	snap.Services[service] = state
+	snap.SavedAt = time.Now()
+
+	return saveSnapshotLocked(snap)
+}
+
+// captureSnapshot force-captures the current proxy configuration for every
+// given service, overwriting any existing entries, and persists the result.
+func captureSnapshot(services []string) (*proxySnapshot, error) {
+	snapshotMu.Lock()
+	defer snapshotMu.Unlock()
+
+	snap, err := loadSnapshotLocked()
+	if err != nil {
+		return nil, err
+	}
+
+	for _, service := range services {
+		state, err := captureServiceState(service)
+		if err != nil {
+			return nil, fmt.Errorf("failed to capture state for %s: %w", service, err)
+		}
+		snap.Services[service] = state
+	}
+	snap.SavedAt = time.Now()
+
+	if err := saveSnapshotLocked(snap); err != nil {
+		return nil, err
+	}
+
+	return snap, nil
+}
+
+// captureServiceState reads back everything we might later need to restore
+// for service, via whichever backend (SystemConfiguration or networksetup)
+// getNetworkServices itself resolved to.
+func captureServiceState(service string) (serviceProxyState, error) {
+	return captureServiceStateBackend(service)
+}
+
+// restoreServiceState re-applies a previously captured proxy configuration
+// to service.
+func restoreServiceState(service string, state serviceProxyState) error {
+	if err := turnOffProxies(service); err != nil {
+		return err
+	}
+
+	return restoreServiceStateBackend(service, state)
+}
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+)
+
+// listenFDsStart is the first file descriptor passed to the process by a
+// socket-activating supervisor (systemd's SD_LISTEN_FDS_START, also used by
+// launchd-compatible activation shims).
+const listenFDsStart = 3
+
+// activatedListener inspects the systemd socket-activation environment
+// variables (LISTEN_PID, LISTEN_FDS) and, if they indicate a socket was
+// handed to this process, wraps fd 3 as a net.Listener. It returns a nil
+// listener (and no error) when no activation env is present, so callers can
+// fall back to creating the socket themselves.
+func activatedListener() (net.Listener, error) {
+	pidStr := os.Getenv("LISTEN_PID")
+	fdsStr := os.Getenv("LISTEN_FDS")
+	if pidStr == "" || fdsStr == "" {
+		return nil, nil
+	}
+
+	pid, err := strconv.Atoi(pidStr)
+	if err != nil {
+		return nil, fmt.Errorf("invalid LISTEN_PID: %w", err)
+	}
+	if pid != os.Getpid() {
+		// Env vars belong to a different process in the chain; ignore them.
+		return nil, nil
+	}
+
+	fds, err := strconv.Atoi(fdsStr)
+	if err != nil {
+		return nil, fmt.Errorf("invalid LISTEN_FDS: %w", err)
+	}
+	if fds < 1 {
+		return nil, nil
+	}
+
+	// We only expect (and use) a single activated socket. net.FileListener
+	// dups the fd internally, so file itself is safe (and needs) to close
+	// right away regardless of whether it succeeded.
+	file := os.NewFile(uintptr(listenFDsStart), "LISTEN_FD_3")
+	listener, err := net.FileListener(file)
+	file.Close()
+	if err != nil {
+		return nil, fmt.Errorf("failed to wrap activated fd as listener: %w", err)
+	}
+
+	// Don't leak activation env vars into anything this process spawns.
+	os.Unsetenv("LISTEN_PID")
+	os.Unsetenv("LISTEN_FDS")
+	os.Unsetenv("LISTEN_FDNAMES")
+
+	if err := checkActivatedListener(listener); err != nil {
+		listener.Close()
+		return nil, err
+	}
+
+	return listener, nil
+}
+
+// isUnixSocket reports whether s is currently serving over a unix domain
+// socket, as opposed to an activated loopback TCP listener.
+func (s *Server) isUnixSocket() bool {
+	_, ok := s.listener.(*net.UnixListener)
+	return ok
+}
+
+// checkActivatedListener guards against a supervisor unit (accidentally or
+// maliciously) activating the helper on something other than a local
+// socket. Every route registered in setupRoutes is unauthenticated, on the
+// assumption that only local processes can reach it; that assumption only
+// holds for a unix socket or a loopback-bound TCP listener.
+func checkActivatedListener(listener net.Listener) error {
+	switch l := listener.(type) {
+	case *net.UnixListener:
+		return nil
+	case *net.TCPListener:
+		addr, ok := l.Addr().(*net.TCPAddr)
+		if ok && addr.IP.IsLoopback() {
+			return nil
+		}
+		return fmt.Errorf("activated TCP listener on %s is not loopback-bound; refusing to serve the unauthenticated proxy-control API on a non-local address", listener.Addr())
+	default:
+		return fmt.Errorf("activated listener type %T is not a unix socket or loopback TCP socket", listener)
+	}
+}
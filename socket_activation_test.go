@@ -0,0 +1,70 @@
+package main
+
+import (
+	"net"
+	"os"
+	"testing"
+)
+
+func TestActivatedListenerNoEnv(t *testing.T) {
+	os.Unsetenv("LISTEN_PID")
+	os.Unsetenv("LISTEN_FDS")
+
+	listener, err := activatedListener()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if listener != nil {
+		t.Fatalf("expected nil listener with no activation env, got %v", listener)
+	}
+}
+
+func TestActivatedListenerWrongPID(t *testing.T) {
+	t.Setenv("LISTEN_PID", "1")
+	t.Setenv("LISTEN_FDS", "1")
+
+	listener, err := activatedListener()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if listener != nil {
+		t.Fatalf("expected nil listener when LISTEN_PID doesn't match, got %v", listener)
+	}
+}
+
+func TestCheckActivatedListenerAcceptsUnix(t *testing.T) {
+	dir := t.TempDir()
+	listener, err := net.Listen("unix", dir+"/test.sock")
+	if err != nil {
+		t.Fatalf("failed to create unix listener: %v", err)
+	}
+	defer listener.Close()
+
+	if err := checkActivatedListener(listener); err != nil {
+		t.Fatalf("expected unix listener to be accepted, got error: %v", err)
+	}
+}
+
+func TestCheckActivatedListenerAcceptsLoopbackTCP(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed to create loopback listener: %v", err)
+	}
+	defer listener.Close()
+
+	if err := checkActivatedListener(listener); err != nil {
+		t.Fatalf("expected loopback TCP listener to be accepted, got error: %v", err)
+	}
+}
+
+func TestCheckActivatedListenerRejectsNonLoopbackTCP(t *testing.T) {
+	listener, err := net.Listen("tcp", "0.0.0.0:0")
+	if err != nil {
+		t.Skipf("could not bind 0.0.0.0: %v", err)
+	}
+	defer listener.Close()
+
+	if err := checkActivatedListener(listener); err == nil {
+		t.Fatal("expected a non-loopback TCP listener to be rejected")
+	}
+}